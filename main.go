@@ -8,15 +8,20 @@ import (
 	"log/slog"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	corev2 "github.com/sensu/core/v2"
 	"github.com/sensu/sensu-plugin-sdk/sensu"
 	"github.com/xo/dburl"
 
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	_ "github.com/microsoft/go-mssqldb"
+	_ "modernc.org/sqlite"
 
 	"github.com/sardinasystems/sensu-go-prometheus-metric-check/utils"
 )
@@ -24,25 +29,49 @@ import (
 // Config represents the check plugin config.
 type Config struct {
 	sensu.PluginConfig
-	DBURL             string
-	Driver            string
-	Host              string
-	Port              int
-	User              string
-	Password          string
-	Database          string
-	Query             string
-	QueryArgs         []string
-	WarningStr        string
-	CriticalStr       string
-	WarningThreshold  utils.NagiosThreshold
-	CriticalThreshold utils.NagiosThreshold
-	Unquote           bool
-	Debug             bool
+	DBURL                 string
+	Driver                string
+	Host                  string
+	Port                  int
+	User                  string
+	Password              string
+	Database              string
+	Query                 string
+	QueryArgs             []string
+	WarningStr            string
+	CriticalStr           string
+	WarningThreshold      utils.NagiosThreshold
+	CriticalThreshold     utils.NagiosThreshold
+	Unquote               bool
+	Debug                 bool
+	AllowUnsafeParams     bool
+	MigrateDir            string
+	OutputFormat          string
+	InfluxMeasurement     string
+	InfluxTags            []string
+	TLSCA                 string
+	TLSCert               string
+	TLSKey                string
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
 }
 
 var (
-	allowedDrivers = []string{"mysql", "postgresql"}
+	allowedDrivers = []string{"mysql", "postgresql", "sqlserver", "sqlite"}
+
+	// defaultPorts holds the well-known TCP port for drivers that are
+	// addressed over the network. Drivers not present here (e.g. sqlite)
+	// have no notion of a port.
+	defaultPorts = map[string]int{
+		"mysql":      3306,
+		"postgresql": 5432,
+		"sqlserver":  1433,
+	}
+
+	// mysqlDSNPassword matches the credentials portion of a mysql DSN
+	// (user:pass@tcp(host:port)/db?params) so the password can be scrubbed
+	// before the DSN is logged.
+	mysqlDSNPassword = regexp.MustCompile(`^([^:/?#@]+):[^@]*@`)
 
 	plugin = Config{
 		PluginConfig: sensu.PluginConfig{
@@ -68,7 +97,7 @@ var (
 			Argument:  "driver",
 			Shorthand: "",
 			Default:   "mysql",
-			Usage:     "DB Driver",
+			Usage:     "DB Driver (mysql, postgresql, sqlserver, sqlite)",
 			Value:     &plugin.Driver,
 			Allow:     allowedDrivers,
 		},
@@ -172,61 +201,379 @@ var (
 			Usage:     "Enable debug log",
 			Value:     &plugin.Debug,
 		},
+		&sensu.PluginConfigOption[bool]{
+			Path:      "allow_unsafe_params",
+			Env:       "SQL_ALLOW_UNSAFE_PARAMS",
+			Argument:  "allow-unsafe-params",
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Allow potentially unsafe query parameters (e.g. allowAllFiles, sslmode=disable) in --dburl",
+			Value:     &plugin.AllowUnsafeParams,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "migrate_dir",
+			Env:       "SQL_MIGRATE_DIR",
+			Argument:  "migrate-dir",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Directory of versioned *.sql migration files to apply before running the check",
+			Value:     &plugin.MigrateDir,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "output_format",
+			Env:       "SQL_OUTPUT_FORMAT",
+			Argument:  "output-format",
+			Shorthand: "",
+			Default:   outputFormatNagios,
+			Usage:     "Output format: nagios (single value), perfdata, or influx",
+			Value:     &plugin.OutputFormat,
+			Allow:     outputFormats,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "influx_measurement",
+			Env:       "SQL_INFLUX_MEASUREMENT",
+			Argument:  "influx-measurement",
+			Shorthand: "",
+			Default:   "sql_select_count_check",
+			Usage:     "Influx line protocol measurement name (used with --output-format=influx)",
+			Value:     &plugin.InfluxMeasurement,
+		},
+		&sensu.SlicePluginConfigOption[string]{
+			Path:      "influx_tags",
+			Env:       "SQL_INFLUX_TAGS",
+			Argument:  "influx-tags",
+			Shorthand: "",
+			Default:   []string{},
+			Usage:     "Additional key=value tags to attach to influx line protocol output (used with --output-format=influx)",
+			Value:     &plugin.InfluxTags,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "tls_ca",
+			Env:       "SQL_TLS_CA",
+			Argument:  "tls-ca",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Path to a PEM CA bundle to verify the DB server certificate",
+			Value:     &plugin.TLSCA,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "tls_cert",
+			Env:       "SQL_TLS_CERT",
+			Argument:  "tls-cert",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Path to a PEM client certificate for TLS client auth (mTLS)",
+			Value:     &plugin.TLSCert,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "tls_key",
+			Env:       "SQL_TLS_KEY",
+			Argument:  "tls-key",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Path to the PEM private key matching --tls-cert",
+			Value:     &plugin.TLSKey,
+		},
+		&sensu.PluginConfigOption[string]{
+			Path:      "tls_server_name",
+			Env:       "SQL_TLS_SERVER_NAME",
+			Argument:  "tls-server-name",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Override the server name used to verify the DB server certificate",
+			Value:     &plugin.TLSServerName,
+		},
+		&sensu.PluginConfigOption[bool]{
+			Path:      "tls_insecure_skip_verify",
+			Env:       "SQL_TLS_INSECURE_SKIP_VERIFY",
+			Argument:  "tls-insecure-skip-verify",
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Skip DB server certificate verification (insecure, for testing only)",
+			Value:     &plugin.TLSInsecureSkipVerify,
+		},
 	}
 )
 
+// NewDB opens the configured database. When --dburl is not given, mysql and
+// postgresql are connected by building the driver's native connector struct
+// directly from the discrete --host/--port/--user/--password/--database
+// fields via sql.OpenDB, rather than string-formatting a DSN: DSN strings
+// are prone to escaping bugs when credentials contain "@", ":", "/" or "?",
+// and can be used to smuggle in extra connection parameters. sqlserver and
+// sqlite, which don't expose a comparable connector API, still go through
+// the DSN builder below. --dburl remains a supported fallback for all
+// drivers, with its query string checked for unsafe parameters.
 func (s *Config) NewDB() (*sql.DB, error) {
-	var err error
-	var u *dburl.URL
-	var dsn string
+	if s.DBURL != "" {
+		return s.newDBFromURL()
+	}
 
-	if s.DBURL == "" {
-		u = &dburl.URL{}
-		u.Driver = s.Driver
-		u.Host = s.Host
-		if s.Port > 0 {
-			u.Host += fmt.Sprintf(":%d", s.Port)
-		}
-		if s.User != "" {
-			u.User = url.UserPassword(s.User, s.Password)
+	switch s.Driver {
+	case "mysql":
+		return s.newMysqlDB()
+	case "postgresql":
+		return s.newPostgresDB()
+	case "sqlserver", "sqlite":
+		return s.newDBFromDSN()
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", s.Driver)
+	}
+}
+
+func (s *Config) newMysqlDB() (*sql.DB, error) {
+	port := s.Port
+	if port == 0 {
+		port = defaultPorts["mysql"]
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = fmt.Sprintf("%s:%d", s.Host, port)
+	cfg.User = s.User
+	cfg.Passwd = s.Password
+	cfg.DBName = s.Database
+
+	tlsConfig, err := s.buildTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		tlsName := fmt.Sprintf("sensu-go-sql-select-count-check-%s", s.Host)
+		if err := mysql.RegisterTLSConfig(tlsName, tlsConfig); err != nil {
+			return nil, err
 		}
-		u.Path = s.Database
-
-		switch s.Driver {
-		case "mysql":
-			dsn, _, err = dburl.GenMysql(u)
-		case "postgresql":
-			dsn, _, err = dburl.GenPostgres(u)
-		default:
-			return nil, fmt.Errorf("unsupported driver: %s", s.Driver)
+		cfg.TLSConfig = tlsName
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.With("driver", s.Driver, "addr", cfg.Addr, "user", cfg.User, "database", cfg.DBName).Debug("opening db...")
+	return sql.OpenDB(connector), nil
+}
+
+func (s *Config) newPostgresDB() (*sql.DB, error) {
+	port := s.Port
+	if port == 0 {
+		port = defaultPorts["postgresql"]
+	}
+
+	cfg, err := pgx.ParseConfig("")
+	if err != nil {
+		return nil, err
+	}
+	cfg.Host = s.Host
+	cfg.Port = uint16(port)
+	cfg.User = s.User
+	cfg.Password = s.Password
+	cfg.Database = s.Database
+
+	tlsConfig, err := s.buildTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		cfg.TLSConfig = tlsConfig
+	}
+
+	slog.With("driver", s.Driver, "host", cfg.Host, "port", cfg.Port, "user", cfg.User, "database", cfg.Database).Debug("opening db...")
+	return stdlib.OpenDB(*cfg), nil
+}
+
+// newDBFromDSN builds a DSN from the discrete config fields for drivers
+// that don't expose a field-based connector (sqlserver, sqlite) and opens
+// it via sql.Open.
+func (s *Config) newDBFromDSN() (*sql.DB, error) {
+	port := s.Port
+	if port == 0 {
+		port = defaultPorts[s.Driver]
+	}
+
+	// go-mssqldb's DSN has no client-certificate parameter, so silently
+	// accepting --tls-cert/--tls-key here would drop mTLS on the floor.
+	// Fail fast instead of connecting without the client cert the caller
+	// asked for.
+	if s.Driver == "sqlserver" && (s.TLSCert != "" || s.TLSKey != "") {
+		return nil, fmt.Errorf("--tls-cert/--tls-key (mTLS client certificates) are not supported with --driver sqlserver")
+	}
+
+	// Validate the remaining TLS flags up front so a bogus --tls-ca fails
+	// fast with a clear error rather than the driver silently connecting
+	// insecurely.
+	if _, err := s.buildTLSConfig(); err != nil {
+		return nil, err
+	}
+
+	u := &dburl.URL{}
+	u.Driver = s.Driver
+	u.Host = s.Host
+	if port > 0 {
+		u.Host += fmt.Sprintf(":%d", port)
+	}
+	if s.User != "" {
+		u.User = url.UserPassword(s.User, s.Password)
+	}
+	u.Path = s.Database
+
+	if s.Driver == "sqlserver" {
+		q := u.Query()
+		if s.TLSInsecureSkipVerify {
+			q.Set("TrustServerCertificate", "true")
+		} else if s.TLSCA != "" {
+			q.Set("certificate", s.TLSCA)
 		}
-	} else {
-		u, err = dburl.Parse(s.DBURL)
-		if u != nil {
-			s.Driver = u.Driver
-			dsn = u.DSN
+		if s.TLSServerName != "" {
+			q.Set("hostNameInCertificate", s.TLSServerName)
 		}
+		u.RawQuery = q.Encode()
+	}
+
+	var dsn string
+	var err error
+	switch s.Driver {
+	case "sqlserver":
+		dsn, _, err = dburl.GenSqlserver(u)
+	case "sqlite":
+		// sqlite has no network address; the "database" is a file path
+		// (or a DSN like "file::memory:?cache=shared").
+		dsn = s.Database
+	default:
+		return nil, fmt.Errorf("unsupported driver: %s", s.Driver)
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	slog.With("driver", s.Driver, "dsn", dsn).Debug("opening db...")
+	slog.With("driver", s.Driver, "dsn", sanitizeDSN(s.Driver, dsn)).Debug("opening db...")
 	return sql.Open(s.Driver, dsn)
 }
 
+// dburlDriverAliases maps a driver name as resolved by dburl to the name
+// used everywhere else in this codebase (allowedDrivers, migrate.go's
+// driver switches, ...). dburl resolves postgres schemes to "postgres",
+// but the rest of the plugin standardizes on "postgresql".
+var dburlDriverAliases = map[string]string{
+	"postgres": "postgresql",
+}
+
+// dburlToSQLDriver maps our internal driver name to the name the driver
+// actually imported by this binary self-registers under, where it differs.
+// "postgresql" has no database/sql driver of that name; this binary imports
+// jackc/pgx/v5/stdlib, which registers as "pgx", not lib/pq's "postgres".
+var dburlToSQLDriver = map[string]string{
+	"postgresql": "pgx",
+}
+
+// newDBFromURL opens a DB from the --dburl flag. Unlike the field-based
+// connectors above this still goes through a DSN, so the parsed query
+// string is checked for parameters that could weaken or bypass security
+// (e.g. allowAllFiles, sslmode=disable) unless --allow-unsafe-params is set.
+func (s *Config) newDBFromURL() (*sql.DB, error) {
+	u, err := dburl.Parse(s.DBURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateUnsafeParams(u.RawQuery, s.AllowUnsafeParams); err != nil {
+		return nil, err
+	}
+
+	s.Driver = u.Driver
+	if mapped, ok := dburlDriverAliases[s.Driver]; ok {
+		s.Driver = mapped
+	}
+	dsn := u.DSN
+
+	sqlDriver := s.Driver
+	if mapped, ok := dburlToSQLDriver[sqlDriver]; ok {
+		sqlDriver = mapped
+	}
+
+	slog.With("driver", sqlDriver, "dsn", sanitizeDSN(s.Driver, dsn)).Debug("opening db...")
+	return sql.Open(sqlDriver, dsn)
+}
+
+// unsafeDSNParams maps DSN query parameter names to a predicate over their
+// value that flags the combination as unsafe.
+var unsafeDSNParams = map[string]func(value string) bool{
+	"allowallfiles": func(string) bool { return true },
+	"sslmode":       func(v string) bool { return v == "disable" },
+	"tls":           func(v string) bool { return v == "false" || v == "skip-verify" },
+}
+
+func validateUnsafeParams(rawQuery string, allow bool) error {
+	if allow || rawQuery == "" {
+		return nil
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range query {
+		isUnsafe, known := unsafeDSNParams[strings.ToLower(key)]
+		if !known {
+			continue
+		}
+
+		for _, v := range values {
+			if isUnsafe(v) {
+				return fmt.Errorf("unsafe DSN parameter %q=%q; pass --allow-unsafe-params to allow it", key, v)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sanitizeDSN replaces the password component of a driver DSN with "***" so
+// it is safe to include in debug logs or error messages. It is best-effort:
+// if the DSN cannot be parsed for the given driver it is returned unchanged.
+func sanitizeDSN(driver, dsn string) string {
+	switch driver {
+	case "postgresql", "postgres", "sqlserver":
+		u, err := url.Parse(dsn)
+		if err != nil || u.User == nil {
+			return dsn
+		}
+
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "***")
+		}
+
+		// url.URL.String percent-encodes "*" in the userinfo; keep the mask literal.
+		return strings.Replace(u.String(), ":%2A%2A%2A@", ":***@", 1)
+	case "mysql":
+		// user:pass@tcp(host:port)/db?params
+		return mysqlDSNPassword.ReplaceAllString(dsn, "$1:***@")
+	default:
+		return dsn
+	}
+}
+
+// DoQuery runs the configured query. database/sql itself already falls
+// back to the non-context Prepare/Query methods when a driver doesn't
+// implement the context-aware interfaces, so callers don't need to.
 func (s *Config) DoQuery(ctx context.Context, db *sql.DB) (*sql.Rows, error) {
 	stmt, err := db.PrepareContext(ctx, s.Query)
 	if err != nil {
 		return nil, err
 	}
 
+	return stmt.QueryContext(ctx, s.queryArgs()...)
+}
+
+func (s *Config) queryArgs() []any {
 	args := make([]any, len(s.QueryArgs))
 	for i, a := range s.QueryArgs {
 		args[i] = a
 	}
 
-	return stmt.QueryContext(ctx, args...)
+	return args
 }
 
 func (s *Config) ExtractValueAndClose(rows *sql.Rows) (result float64, err error) {
@@ -342,11 +689,26 @@ func executeCheck(event *corev2.Event) (int, error) {
 	}
 	defer db.Close()
 
+	if plugin.MigrateDir != "" {
+		if err := RunMigrations(ctx, db, plugin.Driver, plugin.MigrateDir); err != nil {
+			return sensu.CheckStateUnknown, fmt.Errorf("migrate error: %w", err)
+		}
+	}
+
 	rows, err := plugin.DoQuery(ctx, db)
 	if err != nil {
 		return sensu.CheckStateUnknown, fmt.Errorf("query error: %w", err)
 	}
 
+	if plugin.OutputFormat == outputFormatPerfdata || plugin.OutputFormat == outputFormatInflux {
+		metrics, err := plugin.ExtractMetricsAndClose(rows)
+		if err != nil {
+			return sensu.CheckStateUnknown, fmt.Errorf("read error: %w", err)
+		}
+
+		return plugin.reportMetrics(metrics)
+	}
+
 	value, err := plugin.ExtractValueAndClose(rows)
 	if err != nil {
 		return sensu.CheckStateUnknown, fmt.Errorf("read error: %w", err)
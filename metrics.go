@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const (
+	outputFormatNagios   = "nagios"
+	outputFormatPerfdata = "perfdata"
+	outputFormatInflux   = "influx"
+)
+
+var outputFormats = []string{outputFormatNagios, outputFormatPerfdata, outputFormatInflux}
+
+// Metric is a single labeled numeric result extracted from a query.
+type Metric struct {
+	Label string
+	Value float64
+}
+
+// ExtractMetricsAndClose extracts one or more labeled metrics from rows,
+// for use with --output-format=perfdata/influx. Two row shapes are
+// understood:
+//
+//   - A single row with multiple columns: each column becomes a metric
+//     labeled with its column name.
+//   - Multiple rows shaped as (label, value): each row becomes a metric
+//     labeled by its first column, valued by its second.
+//
+// A single column with a single (or no) row behaves like
+// ExtractValueAndClose, producing one metric labeled with the column
+// name. A single column with more than one row has no way to give each
+// row a distinct label, so unlike the (label, value) shape above it is
+// an error rather than emitting several same-keyed metrics, which isn't
+// valid Nagios perfdata and is ambiguous Influx line-protocol output.
+//
+// Unlike ExtractValueAndClose, which silently returns 0 when a
+// single-column query matches no rows, a multi-column query matching no
+// rows is an error here: there is no column name to blame a bogus 0 on,
+// and reporting one anyway would hide a broken query behind a clean OK.
+func (s *Config) ExtractMetricsAndClose(rows *sql.Rows) (metrics []Metric, err error) {
+	defer func() {
+		err = errors.Join(err, rows.Close())
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("No columns returned")
+	}
+
+	values, err := scanAllRows(rows, len(columns))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(columns) == 1:
+		if len(values) > 1 {
+			return nil, fmt.Errorf("query returned %d rows for a single column; use a (label, value) column shape to report multiple metrics", len(values))
+		}
+		if len(values) == 0 {
+			metrics = append(metrics, Metric{Label: columns[0], Value: 0})
+		}
+		for _, row := range values {
+			v, err := s.parseMetricValue(row[0])
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, Metric{Label: columns[0], Value: v})
+		}
+
+	case len(values) == 0:
+		return nil, fmt.Errorf("no rows returned")
+
+	case len(values) == 1:
+		row := values[0]
+		for i, col := range columns {
+			v, err := s.parseMetricValue(row[i])
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, Metric{Label: col, Value: v})
+		}
+
+	default:
+		if len(columns) < 2 {
+			return nil, fmt.Errorf("expected at least 2 columns for label,value rows, got %d", len(columns))
+		}
+		for _, row := range values {
+			v, err := s.parseMetricValue(row[1])
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, Metric{Label: row[0], Value: v})
+		}
+	}
+
+	return metrics, nil
+}
+
+func scanAllRows(rows *sql.Rows, numColumns int) ([][]string, error) {
+	var result [][]string
+
+	for rows.Next() {
+		valuesAny := make([]any, numColumns)
+		for i := range valuesAny {
+			valuesAny[i] = new(string)
+		}
+
+		if err := rows.Scan(valuesAny...); err != nil {
+			return nil, err
+		}
+
+		row := make([]string, numColumns)
+		for i, v := range valuesAny {
+			row[i] = *(v.(*string))
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *Config) parseMetricValue(raw string) (float64, error) {
+	if s.Unquote {
+		unquoted, err := strconv.Unquote(raw)
+		if err != nil {
+			return 0, err
+		}
+		raw = unquoted
+	}
+
+	return strconv.ParseFloat(raw, 64)
+}
+
+// reportMetrics applies the warning/critical thresholds to every metric,
+// escalating to the worst individual state, and prints the result in the
+// configured --output-format.
+func (s *Config) reportMetrics(metrics []Metric) (int, error) {
+	state := sensu.CheckStateOK
+
+	for _, m := range metrics {
+		mState := sensu.CheckStateOK
+		if s.CriticalThreshold.Check(m.Value) {
+			mState = sensu.CheckStateCritical
+		} else if s.WarningThreshold.Check(m.Value) {
+			mState = sensu.CheckStateWarning
+		}
+
+		if mState > state {
+			state = mState
+		}
+	}
+
+	var line string
+	if s.OutputFormat == outputFormatInflux {
+		line = formatInflux(s.InfluxMeasurement, s.InfluxTags, metrics)
+	} else {
+		line = formatPerfdata(stateLabel(state), metrics, s.WarningStr, s.CriticalStr)
+	}
+
+	fmt.Println(line)
+	return state, nil
+}
+
+func stateLabel(state int) string {
+	switch state {
+	case sensu.CheckStateCritical:
+		return "CRITICAL"
+	case sensu.CheckStateWarning:
+		return "WARNING"
+	default:
+		return "OK"
+	}
+}
+
+// formatPerfdata renders metrics as a Nagios perfdata line, e.g.
+// "OK: col1, col2 | col1=5;10;20 col2=3.14;10;20".
+func formatPerfdata(label string, metrics []Metric, warnStr, critStr string) string {
+	names := make([]string, len(metrics))
+	perf := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.Label
+		perf[i] = fmt.Sprintf("%s=%g;%s;%s", m.Label, m.Value, warnStr, critStr)
+	}
+
+	return fmt.Sprintf("%s: %s | %s", label, strings.Join(names, ", "), strings.Join(perf, " "))
+}
+
+// formatInflux renders metrics as an Influx line protocol point, e.g.
+// "sql_select_count_check,env=prod col1=5,col2=3.14".
+func formatInflux(measurement string, tags []string, metrics []Metric) string {
+	var sb strings.Builder
+	sb.WriteString(measurement)
+	for _, tag := range tags {
+		sb.WriteString(",")
+		sb.WriteString(tag)
+	}
+	sb.WriteString(" ")
+
+	fields := make([]string, len(metrics))
+	for i, m := range metrics {
+		fields[i] = fmt.Sprintf("%s=%g", sanitizeInfluxKey(m.Label), m.Value)
+	}
+	sb.WriteString(strings.Join(fields, ","))
+
+	return sb.String()
+}
+
+var influxKeyReplacer = strings.NewReplacer(" ", "_", ",", "_", "=", "_")
+
+func sanitizeInfluxKey(key string) string {
+	return influxKeyReplacer.Replace(key)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// migrationLockKey is an arbitrary, fixed advisory-lock identifier used so
+// that multiple sensu agents bootstrapping the same schema concurrently
+// serialize against each other instead of racing.
+const migrationLockKey = "sensu-go-sql-select-count-check:schema_migrations"
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_.*\.sql$`)
+
+// migration is a single up-only, versioned SQL file (e.g. 0001_init.sql).
+type migration struct {
+	version int64
+	name    string
+	path    string
+}
+
+// loadMigrations reads *.sql files matching `<version>_<name>.sql` from dir
+// and returns them sorted by version. Files that don't match the naming
+// convention are ignored.
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrate-dir: %w", err)
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+
+		migrations = append(migrations, migration{
+			version: version,
+			name:    e.Name(),
+			path:    filepath.Join(dir, e.Name()),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// RunMigrations applies any not-yet-applied *.sql files in dir to db,
+// tracking progress in a schema_migrations table. This lets a check ship
+// together with the DB objects (a monitoring view, say) it depends on, so
+// a freshly deployed agent can self-provision its monitoring schema.
+//
+// Migrations are applied under a per-driver advisory lock and each one
+// runs in its own transaction; if any migration fails the remaining ones
+// are not attempted and the error is returned so the caller can skip
+// running the check.
+func RunMigrations(ctx context.Context, db *sql.DB, driver, dir string) error {
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := acquireMigrationLock(ctx, db, driver)
+	if err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer unlock()
+
+	if err := ensureSchemaMigrationsTable(ctx, db, driver); err != nil {
+		return fmt.Errorf("ensure schema_migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrationVersions(ctx, db)
+	if err != nil {
+		return fmt.Errorf("read schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			slog.With("version", m.version, "name", m.name).Debug("migration already applied, skipping")
+			continue
+		}
+
+		if err := applyMigration(ctx, db, driver, m); err != nil {
+			return fmt.Errorf("apply migration %s: %w", m.name, err)
+		}
+
+		slog.With("version", m.version, "name", m.name).Info("applied migration")
+	}
+
+	return nil
+}
+
+func ensureSchemaMigrationsTable(ctx context.Context, db *sql.DB, driver string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    BIGINT PRIMARY KEY,
+	applied_at %s NOT NULL
+)`, timestampColumnType(driver)))
+	return err
+}
+
+// timestampColumnType returns the column type used for schema_migrations's
+// applied_at column. sqlserver reserves TIMESTAMP as a synonym for
+// rowversion, an auto-generated column that rejects explicit INSERTs, so it
+// needs its own type.
+func timestampColumnType(driver string) string {
+	if driver == "sqlserver" {
+		return "DATETIME2"
+	}
+	return "TIMESTAMP"
+}
+
+func appliedMigrationVersions(ctx context.Context, db *sql.DB) (map[int64]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, driver string, m migration) error {
+	body, err := os.ReadFile(m.path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, string(body)); err != nil {
+		return err
+	}
+
+	insert := "INSERT INTO schema_migrations (version, applied_at) VALUES (" + placeholder(driver, 1) + ", CURRENT_TIMESTAMP)"
+	if _, err := tx.ExecContext(ctx, insert, m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// placeholder returns the nth (1-based) bind parameter marker for driver.
+func placeholder(driver string, n int) string {
+	switch driver {
+	case "postgresql":
+		return fmt.Sprintf("$%d", n)
+	case "sqlserver":
+		return fmt.Sprintf("@p%d", n)
+	}
+	return "?"
+}
+
+// acquireMigrationLock takes a session-scoped advisory lock so concurrent
+// migration runs against the same database serialize instead of racing.
+// Drivers without an advisory-locking primitive proceed unlocked.
+func acquireMigrationLock(ctx context.Context, db *sql.DB, driver string) (unlock func() error, err error) {
+	switch driver {
+	case "mysql":
+		var got int
+		if err := db.QueryRowContext(ctx, `SELECT GET_LOCK(?, 10)`, migrationLockKey).Scan(&got); err != nil {
+			return nil, err
+		}
+		if got != 1 {
+			return nil, fmt.Errorf("could not acquire migration lock %q", migrationLockKey)
+		}
+
+		return func() error {
+			_, err := db.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, migrationLockKey)
+			return err
+		}, nil
+
+	case "postgresql":
+		if _, err := db.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, migrationLockKey); err != nil {
+			return nil, err
+		}
+
+		return func() error {
+			_, err := db.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, migrationLockKey)
+			return err
+		}, nil
+
+	default:
+		return func() error { return nil }, nil
+	}
+}
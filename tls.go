@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// buildTLSConfig constructs a *tls.Config from the --tls-* flags. It
+// returns (nil, nil) when none of the flags are set, so callers can treat
+// a nil config as "use the driver's own default behavior".
+func (s *Config) buildTLSConfig() (*tls.Config, error) {
+	if s.TLSCA == "" && s.TLSCert == "" && s.TLSKey == "" && s.TLSServerName == "" && !s.TLSInsecureSkipVerify {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         s.TLSServerName,
+		InsecureSkipVerify: s.TLSInsecureSkipVerify,
+	}
+
+	if s.TLSCA != "" {
+		pem, err := os.ReadFile(s.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("read --tls-ca: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("--tls-ca %q: no certificates found", s.TLSCA)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if s.TLSCert != "" || s.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load --tls-cert/--tls-key: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
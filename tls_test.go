@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDBBogusCA(t *testing.T) {
+	initLogger(t)
+	assert := assert.New(t)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, []byte("not a certificate"), 0o644))
+
+	config := &Config{Driver: "mysql", Host: "localhost", Port: 3306, Database: "test", TLSCA: caPath}
+
+	db, err := config.NewDB()
+	assert.Error(err)
+	assert.Nil(db)
+}
+
+func TestNewDBSqlserverClientCertUnsupported(t *testing.T) {
+	initLogger(t)
+	assert := assert.New(t)
+
+	config := &Config{
+		Driver:   "sqlserver",
+		Host:     "localhost",
+		Database: "test",
+		TLSCert:  "/tmp/does-not-matter.pem",
+		TLSKey:   "/tmp/does-not-matter.key",
+	}
+
+	db, err := config.NewDB()
+	assert.Error(err)
+	assert.Nil(db)
+}